@@ -5,6 +5,8 @@
 package amr
 
 import (
+	"context"
+	"errors"
 	"io"
 	"os"
 	"runtime"
@@ -13,21 +15,60 @@ import (
 
 const bufSize = 32 * 1024 // same buffer size as io.copy
 
-// A Writer manages writing and creation of asynchronous readers on a given os.File.
+// A Writer manages writing and creation of asynchronous readers on a given File.
 // It implements io.CloseWriter.
 //
 type Writer struct {
-	c   *sync.Cond // sync with readers
-	f   *os.File   // file descriptor
-	w   int64      // bytes written so far
-	err error      // last error
+	mu            sync.Mutex
+	notify        chan struct{}        // closed and replaced every time state changes; see broadcastLocked
+	f             File                 // backend storage
+	w             int64                // bytes written so far
+	err           error                // last error
+	activeReaders int                  // readers created by NewReader that have not been closed yet
+	doneFn        func(error)          // set by OnDone
+	doneFired     bool                 // true once doneFn has been called
+	maxLead       int64                // set by WithMaxLead; <= 0 disables backpressure
+	readers       map[*reader]struct{} // registry used to compute the slowest reader's position; only populated when maxLead > 0
 }
 
 type reader struct {
-	f   *os.File
-	w   *Writer
-	r   int64 // bytes read so far
-	err error // last error
+	f         FileReader
+	w         *Writer
+	r         int64 // bytes read so far via Read/Seek; set under w.mu when maxLead > 0 (see setR)
+	hi        int64 // high-water mark reached by ReadAt, independent of r; only tracked when maxLead > 0, under w.mu
+	err       error // last error
+	clampSeek bool  // clamp Seek to the write frontier instead of erroring
+	closed    bool  // true once Close has decremented w.activeReaders
+}
+
+// ErrSeekPastFrontier is returned by reader.Seek when the requested position
+// is past the number of bytes committed so far by the writer, and the
+// reader was not created with WithSeekClamp.
+var ErrSeekPastFrontier = errors.New("amr: seek past write frontier")
+
+// Option configures optional parameters for Create, WrapFile and Wrap.
+type Option func(*writerConfig)
+
+type writerConfig struct {
+	fs      FileSystem
+	maxLead int64
+}
+
+// WithFileSystem sets the FileSystem used to create the backing File. If
+// not specified, the default is DiskFS. It has no effect on WrapFile or
+// Wrap, which are always given an already-open File.
+func WithFileSystem(fs FileSystem) Option {
+	return func(c *writerConfig) { c.fs = fs }
+}
+
+// WithMaxLead bounds how far ahead of its slowest reader the writer is
+// allowed to get, in bytes. Once the gap between the number of bytes
+// written and the least-advanced active reader exceeds n, Write blocks
+// until a reader advances, closes or errors, so a fast producer cannot
+// unboundedly outrun its consumers. A Writer with no active readers is
+// never blocked. The default, n <= 0, disables backpressure.
+func WithMaxLead(n int64) Option {
+	return func(c *writerConfig) { c.maxLead = n }
 }
 
 // ReadCloser wraps an io.ReadCloser with the Err method that returns the first error that occurred in Read().
@@ -38,25 +79,84 @@ type ReadCloser interface {
 	Err() error
 }
 
+// ReadSeekerCloser extends ReadCloser with Seek, ReadAt and ReadContext, as
+// returned by NewReader. ReadAt may be called concurrently from multiple
+// goroutines, independently of Read and Seek, which share the reader's own
+// cursor.
+type ReadSeekerCloser interface {
+	ReadCloser
+	io.Seeker
+	io.ReaderAt
+
+	// ReadContext is like Read but also observes ctx: if ctx is cancelled
+	// while the call is blocked waiting for more data, it returns
+	// ctx.Err() for that call only. Unlike the terminal error set by
+	// Cancel, the reader remains usable for subsequent reads with a fresh
+	// context.
+	ReadContext(ctx context.Context, p []byte) (int, error)
+}
+
+// ReaderOption configures optional parameters for NewReader.
+type ReaderOption func(*readerConfig)
+
+type readerConfig struct {
+	clampSeek bool
+}
+
+// WithSeekClamp controls what Seek does when the requested position is past
+// the write frontier (i.e. past the number of bytes committed so far by the
+// writer). If clamp is true, Seek clamps the position to the frontier
+// instead of returning ErrSeekPastFrontier. The default is to return an
+// error.
+func WithSeekClamp(clamp bool) ReaderOption {
+	return func(c *readerConfig) { c.clampSeek = clamp }
+}
+
 // Create creates the named file with mode 0666 (before umask). Create fails if
 // the file already exists. If successful, methods on the returned Writer can be
 // used for output; the associated file descriptor has mode O_WRONLY. If there
 // is an error, it will be of type *PathError.
 //
+// By default the file is created on the local disk. Use WithFileSystem to
+// create it on another FileSystem instead (e.g. one returned by NewMemFS).
+//
 // Clients must call Close() on the returned writer to unregister the file as
 // active and to allow read operations on the same file to complete successfully
 // (i.e. readers will not receive io.EOF until the writer is closed).
 //
-func Create(name string) (*Writer, error) {
-	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+func Create(name string, opts ...Option) (*Writer, error) {
+	cfg := writerConfig{fs: DiskFS}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	f, err := cfg.fs.Create(name)
 	if err != nil {
 		return nil, err
 	}
-	w := &Writer{
-		c: sync.NewCond(&sync.Mutex{}),
-		f: f,
+	return WrapFile(f, 0, opts...), nil
+}
+
+// WrapFile returns a Writer for the given File. The writer will assume that
+// it will start writing at the given offset (no seeking is performed by
+// this function). WrapFile is the generic counterpart to Wrap: it accepts
+// any File, which allows a Writer to be backed by storage other than disk
+// (e.g. a File obtained from NewMemFS).
+//
+// Callers must make sure that all further io operations on that File will be
+// performed through the returned Writer. Failing to do so will result in
+// unexpected behavior from the Writer.
+//
+func WrapFile(f File, offset int64, opts ...Option) *Writer {
+	cfg := writerConfig{}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	return &Writer{
+		notify:  make(chan struct{}),
+		f:       f,
+		w:       offset,
+		maxLead: cfg.maxLead,
 	}
-	return w, nil
 }
 
 // Wrap returns a Writer for the given os.File. The file must be writable and
@@ -67,12 +167,8 @@ func Create(name string) (*Writer, error) {
 // performed through the returned Writer. Failing to do so will result in
 // unexpected behavior from the Writer.
 //
-func Wrap(f *os.File, offset int64) *Writer {
-	return &Writer{
-		c: sync.NewCond(&sync.Mutex{}),
-		f: f,
-		w: offset,
-	}
+func Wrap(f *os.File, offset int64, opts ...Option) *Writer {
+	return WrapFile(diskFile{f}, offset, opts...)
 }
 
 // Name returns the name of the file as presented to Create
@@ -83,31 +179,150 @@ func (w *Writer) Name() string {
 // Err returns the first error encountered by the writer, or nil if no erorrs occurred.
 // May return io.EOF if the writer has been closed cleanly with no other errors.
 func (w *Writer) Err() error {
-	w.c.L.Lock()
+	w.mu.Lock()
 	err := w.err
-	w.c.L.Unlock()
+	w.mu.Unlock()
 	return err
 }
 
+// broadcastLocked wakes every goroutine currently waiting on the writer's
+// state (new data, Close or Cancel). w.mu must be held by the caller.
+func (w *Writer) broadcastLocked() {
+	close(w.notify)
+	w.notify = make(chan struct{})
+}
+
+// checkDoneLocked reports whether the OnDone callback should fire: the
+// writer must be done (closed or cancelled) and every reader closed, and
+// the callback must not have fired yet. If so, it marks the callback as
+// fired and returns it along with the terminal error, for the caller to
+// invoke once w.mu has been released. w.mu must be held by the caller.
+func (w *Writer) checkDoneLocked() (fn func(error), terminalErr error, fire bool) {
+	if w.doneFn == nil || w.doneFired || w.err == nil || w.activeReaders > 0 {
+		return nil, nil, false
+	}
+	w.doneFired = true
+	return w.doneFn, w.err, true
+}
+
+// OnDone registers fn to be called exactly once, after the writer has been
+// closed or cancelled and every reader returned by NewReader has since been
+// closed. fn receives the writer's terminal error: io.EOF for a clean
+// completion, or the error passed to Cancel. If the writer is already done
+// when OnDone is called, fn is invoked immediately, unless a previously
+// registered callback has already fired, in which case fn is never called.
+// OnDone replaces any previously registered callback.
+func (w *Writer) OnDone(fn func(err error)) {
+	w.mu.Lock()
+	w.doneFn = fn
+	cfn, cerr, fire := w.checkDoneLocked()
+	w.mu.Unlock()
+	if fire {
+		cfn(cerr)
+	}
+}
+
+// ActiveReaders returns the number of readers created by NewReader that
+// have not yet been closed.
+func (w *Writer) ActiveReaders() int {
+	w.mu.Lock()
+	n := w.activeReaders
+	w.mu.Unlock()
+	return n
+}
+
+// minReaderLocked returns the smallest position among readers currently
+// registered for backpressure, where a reader's position is the furthest it
+// has advanced via either Read/Seek (r.r) or ReadAt (r.hi). ok is false if
+// there are none, in which case pos is meaningless. w.mu must be held by the
+// caller.
+func (w *Writer) minReaderLocked() (pos int64, ok bool) {
+	for r := range w.readers {
+		p := r.r
+		if r.hi > p {
+			p = r.hi
+		}
+		if !ok || p < pos {
+			pos, ok = p, true
+		}
+	}
+	return pos, ok
+}
+
+// waitUntil blocks until cond returns true or ctx is done, whichever comes
+// first; a nil ctx never interrupts the wait. w.mu must be held on entry and
+// is held again on return, including when an error is returned.
+func (w *Writer) waitUntil(ctx context.Context, cond func() bool) error {
+	for !cond() {
+		ch := w.notify
+		w.mu.Unlock()
+		if ctx == nil {
+			<-ch
+		} else {
+			select {
+			case <-ch:
+			case <-ctx.Done():
+				w.mu.Lock()
+				return ctx.Err()
+			}
+		}
+		w.mu.Lock()
+	}
+	return nil
+}
+
+// Write implements io.Writer.
 func (w *Writer) Write(p []byte) (n int, err error) {
+	return w.writeCtx(nil, p)
+}
+
+// WriteContext is like Write but also observes ctx, returning ctx.Err() if
+// the call is cancelled while blocked (e.g. waiting for a slow reader under
+// WithMaxLead). ctx is also checked after each chunk of up to 32 KiB has been
+// written, so a cancelled ctx ends a long write early with a partial count.
+func (w *Writer) WriteContext(ctx context.Context, p []byte) (n int, err error) {
+	return w.writeCtx(ctx, p)
+}
+
+func (w *Writer) writeCtx(ctx context.Context, p []byte) (n int, err error) {
 	// split the write in bufSize chunks so that we can update readers at regular intervals
 	tot := len(p)
 	for n < tot && err == nil {
-		var t int
-		if len(p) > bufSize {
-			t, err = w.f.Write(p[:bufSize])
-		} else {
-			t, err = w.f.Write(p)
+		chunk := p
+		if len(chunk) > bufSize {
+			chunk = chunk[:bufSize]
 		}
+		w.mu.Lock()
+		off := w.w
+		w.mu.Unlock()
+
+		var t int
+		t, err = w.f.WriteAt(chunk, off)
 		n += t
-		w.c.L.Lock()
+		w.mu.Lock()
 		w.w += int64(t)
 		if err != nil {
 			w.err = err
 		}
-		w.c.L.Unlock()
-		w.c.Broadcast()
+		w.broadcastLocked()
+		if err == nil && w.maxLead > 0 {
+			cerr := w.waitUntil(ctx, func() bool {
+				min, ok := w.minReaderLocked()
+				return !ok || w.err != nil || w.w-min <= w.maxLead
+			})
+			if cerr != nil {
+				w.mu.Unlock()
+				return n, cerr
+			}
+		}
+		w.mu.Unlock()
 		p = p[t:]
+
+		if err == nil && ctx != nil {
+			if cerr := ctx.Err(); cerr != nil {
+				return n, cerr
+			}
+		}
 	}
 	return n, err
 }
@@ -118,60 +333,135 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 //
 func (w *Writer) Close() error {
 	// notify readers
-	w.c.L.Lock()
+	w.mu.Lock()
 	if w.err == nil {
 		w.err = io.EOF
 	}
-	w.c.L.Unlock()
-	w.c.Broadcast()
-	return w.f.Close()
+	w.broadcastLocked()
+	fn, terr, fire := w.checkDoneLocked()
+	w.mu.Unlock()
+	err := w.f.Close()
+	if fire {
+		fn(terr)
+	}
+	return err
 }
 
 // Cancel cancels the writer as well as all readers reading the same file.
 // The given error will be propagated to all pending and future Read/Write calls.
 //
 func (w *Writer) Cancel(err error) {
-	w.c.L.Lock()
+	w.mu.Lock()
 	w.err = err
-	w.c.L.Unlock()
-	w.c.Broadcast()
+	w.broadcastLocked()
+	fn, terr, fire := w.checkDoneLocked()
+	w.mu.Unlock()
+	if fire {
+		fn(terr)
+	}
 }
 
-// NewReader returns a ReadCloser that reads from the same file name that
-// was used in the call to Create. In some cases it may be advisable to call
-// path.Abs() on the file name before calling Create and NewReader.
+// NewReader returns a ReadSeekerCloser that reads from the same file name
+// that was used in the call to Create. In some cases it may be advisable to
+// call path.Abs() on the file name before calling Create and NewReader.
 //
-func (w *Writer) NewReader() (ReadCloser, error) {
-	w.c.L.Lock()
-	defer w.c.L.Unlock()
+func (w *Writer) NewReader(opts ...ReaderOption) (ReadSeekerCloser, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
 	if w.err != nil && w.err != io.EOF {
 		return nil, w.err
 	}
-	f, err := os.Open(w.f.Name())
+	f, err := w.f.Open()
 	if err != nil {
 		return nil, err
 	}
-	r := &reader{f, w, 0, nil}
+	cfg := readerConfig{}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	r := &reader{f: f, w: w, clampSeek: cfg.clampSeek}
+	w.activeReaders++
+	if w.maxLead > 0 {
+		if w.readers == nil {
+			w.readers = make(map[*reader]struct{})
+		}
+		w.readers[r] = struct{}{}
+	}
 	runtime.SetFinalizer(r, (*reader).Close)
 	return r, nil
 }
 
-// done removes reference to writer and sets error.
+// NewReaderContext is like NewReader but accepts a context.Context. Opening
+// a reader never blocks on the writer's state, so ctx is only checked
+// before the call is made.
+func (w *Writer) NewReaderContext(ctx context.Context, opts ...ReaderOption) (ReadSeekerCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return w.NewReader(opts...)
+}
+
+// done records the first terminal error seen by the reader and removes it
+// from its writer's backpressure registry, since an errored or exhausted
+// reader must not keep capping the writer's lead.
 func (r *reader) done(err error) {
 	if r.err == nil {
 		r.err = err
+		r.deregister()
+	}
+}
+
+// deregister removes r from its writer's backpressure registry, if any, and
+// wakes the writer in case it is blocked in Write waiting on r. It is a
+// no-op unless WithMaxLead is in effect.
+func (r *reader) deregister() {
+	w := r.w
+	if w.maxLead <= 0 {
+		return
+	}
+	w.mu.Lock()
+	if _, ok := w.readers[r]; ok {
+		delete(w.readers, r)
+		w.broadcastLocked()
 	}
-	if r.w != nil {
-		r.w = nil
+	w.mu.Unlock()
+}
+
+// setR updates r.r to abs and wakes the writer, so a blocked Write can
+// recompute backpressure. r.r is only read by minReaderLocked from the
+// writer's goroutine while WithMaxLead is in effect, so it is safe to set
+// directly when backpressure is disabled; otherwise it must be set under
+// w.mu.
+func (r *reader) setR(abs int64) {
+	w := r.w
+	if w.maxLead <= 0 {
+		r.r = abs
+		return
 	}
+	w.mu.Lock()
+	r.r = abs
+	w.broadcastLocked()
+	w.mu.Unlock()
 }
 
 func (r *reader) Err() error {
 	return r.err
 }
 
+// Read implements io.Reader.
 func (r *reader) Read(p []byte) (n int, err error) {
+	return r.readCtx(nil, p)
+}
+
+// ReadContext is like Read but also observes ctx. If ctx is cancelled while
+// blocked waiting for more data, it returns ctx.Err() for that call only;
+// the reader remains usable on subsequent reads with a fresh context.
+func (r *reader) ReadContext(ctx context.Context, p []byte) (n int, err error) {
+	return r.readCtx(ctx, p)
+}
+
+func (r *reader) readCtx(ctx context.Context, p []byte) (n int, err error) {
 	w := r.w
 
 	if r.err != nil {
@@ -183,23 +473,25 @@ func (r *reader) Read(p []byte) (n int, err error) {
 	}
 
 	// get bytes written so far && wait for more if needed
-	w.c.L.Lock()
+	w.mu.Lock()
 	if r.r == w.w && w.err == io.EOF {
 		// wirter EOF and we've read all written bytes, we're done
-		w.c.L.Unlock()
+		w.mu.Unlock()
 		r.done(io.EOF)
 		return 0, io.EOF
 	}
 	// wait for state change
-	for w.err == nil && r.r == w.w {
-		w.c.Wait()
+	cerr := w.waitUntil(ctx, func() bool { return w.err != nil || r.r != w.w })
+	if cerr != nil {
+		w.mu.Unlock()
+		return 0, cerr
 	}
 	tot := w.w
 	if w.err != nil && (w.err != io.EOF || tot == r.r) {
 		// again, ignore EOF unless we have no unread bytes
 		err = w.err
 	}
-	w.c.L.Unlock()
+	w.mu.Unlock()
 
 	if err != nil {
 		r.done(err)
@@ -208,11 +500,11 @@ func (r *reader) Read(p []byte) (n int, err error) {
 
 	max := tot - r.r // we may be reading files over 4GB, this needs to be int64
 	if int64(len(p)) >= max {
-		n, err = r.f.Read(p[:max])
+		n, err = r.f.ReadAt(p[:max], r.r)
 	} else {
-		n, err = r.f.Read(p)
+		n, err = r.f.ReadAt(p, r.r)
 	}
-	r.r += int64(n)
+	r.setR(r.r + int64(n))
 	if err != nil {
 		r.done(err)
 	}
@@ -221,5 +513,121 @@ func (r *reader) Read(p []byte) (n int, err error) {
 
 func (r *reader) Close() error {
 	// do not call done. Should be triggered by an error on the next read.
+	w := r.w
+	w.mu.Lock()
+	var fn func(error)
+	var terr error
+	var fire bool
+	if !r.closed {
+		r.closed = true
+		w.activeReaders--
+		if _, ok := w.readers[r]; ok {
+			delete(w.readers, r)
+		}
+		w.broadcastLocked()
+		fn, terr, fire = w.checkDoneLocked()
+	}
+	w.mu.Unlock()
+	if fire {
+		fn(terr)
+	}
 	return r.f.Close()
 }
+
+// Seek implements io.Seeker. The resulting offset is validated against the
+// write frontier (the number of bytes committed so far by the writer): a
+// position past the frontier is rejected with ErrSeekPastFrontier, unless
+// the reader was created with WithSeekClamp, in which case it is clamped to
+// the frontier instead. SeekEnd blocks until at least one byte has been
+// written, or returns immediately if the writer is already closed or
+// cancelled.
+func (r *reader) Seek(offset int64, whence int) (int64, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	w := r.w
+
+	w.mu.Lock()
+	if whence == io.SeekEnd {
+		w.waitUntil(nil, func() bool { return w.w != 0 || w.err != nil })
+	}
+	frontier := w.w
+	w.mu.Unlock()
+
+	var base int64
+	switch whence {
+	case io.SeekStart:
+		base = 0
+	case io.SeekCurrent:
+		base = r.r
+	case io.SeekEnd:
+		base = frontier
+	default:
+		return r.r, errors.New("amr: reader.Seek: invalid whence")
+	}
+
+	abs := base + offset
+	if abs < 0 {
+		return r.r, errors.New("amr: reader.Seek: negative position")
+	}
+	if abs > frontier {
+		if !r.clampSeek {
+			return r.r, ErrSeekPastFrontier
+		}
+		abs = frontier
+	}
+
+	r.setR(abs)
+	return abs, nil
+}
+
+// ReadAt implements io.ReaderAt. Unlike Read, it does not use or advance the
+// reader's own cursor, so a single reader's ReadAt can be called
+// concurrently from multiple goroutines. It blocks until enough bytes have
+// been committed by the writer to satisfy the request, or until the writer
+// errors or reaches EOF.
+func (r *reader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("amr: reader.ReadAt: negative offset")
+	}
+
+	w := r.w
+	need := off + int64(len(p))
+
+	w.mu.Lock()
+	w.waitUntil(nil, func() bool { return w.err != nil || w.w >= need })
+	tot, werr := w.w, w.err
+	w.mu.Unlock()
+
+	avail := tot - off
+	if avail < 0 {
+		avail = 0
+	}
+	if avail < int64(len(p)) {
+		p = p[:avail]
+		if werr != nil {
+			err = werr
+		}
+	}
+	if len(p) == 0 {
+		if err == nil {
+			err = io.EOF
+		}
+		return 0, err
+	}
+
+	n, rerr := r.f.ReadAt(p, off)
+	if rerr != nil && err == nil {
+		err = rerr
+	}
+	if w.maxLead > 0 {
+		hi := off + int64(n)
+		w.mu.Lock()
+		if hi > r.hi {
+			r.hi = hi
+			w.broadcastLocked()
+		}
+		w.mu.Unlock()
+	}
+	return n, err
+}
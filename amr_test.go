@@ -2,6 +2,7 @@ package amr_test
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"errors"
 	"fmt"
@@ -376,3 +377,533 @@ func TestReader_Close(t *testing.T) {
 		t.Fatal("Unexpected nil error from Read")
 	}
 }
+
+func TestReader_Seek(t *testing.T) {
+	name := testFileName()
+	defer removeTestFile(name)
+	w, err := amr.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if _, err := w.Write([]byte("Hello, World!")); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := w.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	b := make([]byte, 5)
+	if _, err := io.ReadFull(r, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello" {
+		t.Fatalf("got %q, want %q", b, "Hello")
+	}
+
+	// seek backward
+	pos, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != 0 {
+		t.Fatalf("Seek: got position %d, want 0", pos)
+	}
+	if _, err := io.ReadFull(r, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello" {
+		t.Fatalf("got %q, want %q", b, "Hello")
+	}
+
+	// seek to end
+	pos, err = r.Seek(0, io.SeekEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != 13 {
+		t.Fatalf("Seek: got position %d, want 13", pos)
+	}
+
+	// seeking past the write frontier is an error by default
+	_, err = r.Seek(1, io.SeekEnd)
+	if err != amr.ErrSeekPastFrontier {
+		t.Fatalf("Seek past frontier: got error %v, want %v", err, amr.ErrSeekPastFrontier)
+	}
+
+	r2, err := w.NewReader(amr.WithSeekClamp(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r2.Close()
+
+	// with WithSeekClamp, seeking past the frontier clamps instead of erroring
+	pos, err = r2.Seek(100, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != 13 {
+		t.Fatalf("Seek with clamp: got position %d, want 13", pos)
+	}
+}
+
+func TestReader_ReadAt(t *testing.T) {
+	name := testFileName()
+	defer removeTestFile(name)
+	w, err := amr.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := w.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var data = make([]byte, 256*1024)
+	rand.Read(data)
+
+	var wg sync.WaitGroup
+	const clients = 4
+	wg.Add(clients)
+	for i := 0; i < clients; i++ {
+		go func(off int64) {
+			defer wg.Done()
+			b := make([]byte, 1024)
+			n, err := r.ReadAt(b, off)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(b[:n], data[off:off+int64(n)]) {
+				t.Error("ReadAt: data mismatch")
+			}
+		}(int64(i) * 32 * 1024)
+	}
+
+	// race ReadAt against Write
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	wg.Wait()
+}
+
+func TestReader_ReadContext_cancel(t *testing.T) {
+	name := testFileName()
+	defer removeTestFile(name)
+	w, err := amr.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	r, err := w.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	b := make([]byte, 16)
+	_, err = r.ReadContext(ctx, b)
+	if err != context.Canceled {
+		t.Fatalf("ReadContext: got error %v, want %v", err, context.Canceled)
+	}
+
+	// the reader must still be usable with a fresh context
+	if _, err := w.Write([]byte("Hello")); err != nil {
+		t.Fatal(err)
+	}
+	n, err := r.ReadContext(context.Background(), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:n]) != "Hello" {
+		t.Fatalf("ReadContext: got %q, want %q", b[:n], "Hello")
+	}
+}
+
+func TestWriter_WriteContext_cancel(t *testing.T) {
+	name := testFileName()
+	defer removeTestFile(name)
+	w, err := amr.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	data := make([]byte, 2*32*1024)
+	n, err := w.WriteContext(ctx, data)
+	if err != context.Canceled {
+		t.Fatalf("WriteContext: got error %v, want %v", err, context.Canceled)
+	}
+	if n <= 0 || n >= len(data) {
+		t.Fatalf("WriteContext: got n=%d, want a partial write", n)
+	}
+}
+
+func TestWriter_OnDone(t *testing.T) {
+	name := testFileName()
+	defer removeTestFile(name)
+	w, err := amr.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r1, err := w.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := w.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := w.ActiveReaders(); n != 2 {
+		t.Fatalf("ActiveReaders: got %d, want 2", n)
+	}
+
+	var mu sync.Mutex
+	var gotErr error
+	var calls int
+	w.OnDone(func(err error) {
+		mu.Lock()
+		gotErr = err
+		calls++
+		mu.Unlock()
+	})
+
+	w.Write([]byte("data"))
+	w.Close()
+	r1.Close()
+
+	mu.Lock()
+	n := calls
+	mu.Unlock()
+	if n != 0 {
+		t.Fatalf("OnDone fired with %d reader(s) still open", w.ActiveReaders())
+	}
+
+	r2.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 1 {
+		t.Fatalf("OnDone: got %d calls, want 1", calls)
+	}
+	if gotErr != io.EOF {
+		t.Fatalf("OnDone: got error %v, want %v", gotErr, io.EOF)
+	}
+	if n := w.ActiveReaders(); n != 0 {
+		t.Fatalf("ActiveReaders: got %d, want 0", n)
+	}
+
+	// closing an already-closed reader must not decrement again or refire
+	r2.Close()
+	if n := w.ActiveReaders(); n != 0 {
+		t.Fatalf("ActiveReaders after double close: got %d, want 0", n)
+	}
+	if calls != 1 {
+		t.Fatalf("OnDone refired on double close: got %d calls, want 1", calls)
+	}
+}
+
+func TestWriter_OnDone_lateRegistration(t *testing.T) {
+	name := testFileName()
+	defer removeTestFile(name)
+	w, err := amr.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := w.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	r.Close()
+
+	called := make(chan error, 1)
+	w.OnDone(func(err error) { called <- err })
+
+	select {
+	case err := <-called:
+		if err != io.EOF {
+			t.Fatalf("OnDone: got error %v, want %v", err, io.EOF)
+		}
+	default:
+		t.Fatal("OnDone: callback not invoked for an already-done writer")
+	}
+}
+
+func TestWriter_WithMaxLead(t *testing.T) {
+	name := testFileName()
+	defer removeTestFile(name)
+	const maxLead = 64 * 1024
+	w, err := amr.Create(name, amr.WithMaxLead(maxLead))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	r, err := w.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	data := make([]byte, 4*maxLead)
+	rand.Read(data)
+
+	writeDone := make(chan struct{})
+	go func() {
+		defer close(writeDone)
+		if _, err := w.Write(data); err != nil {
+			t.Errorf("Write: %v", err)
+		}
+	}()
+
+	// give the writer a chance to run ahead; it must block well before
+	// writing all of data since nothing has been read yet.
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-writeDone:
+		t.Fatal("Write completed without any reader progress; WithMaxLead had no effect")
+	default:
+	}
+
+	b := make([]byte, 1024)
+	var read int
+	for read < len(data) {
+		n, err := r.Read(b)
+		read += n
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case <-writeDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Write did not unblock after the reader caught up")
+	}
+}
+
+func TestWriter_WithMaxLead_multiReader(t *testing.T) {
+	name := testFileName()
+	defer removeTestFile(name)
+	const maxLead = 64 * 1024
+	w, err := amr.Create(name, amr.WithMaxLead(maxLead))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 4*maxLead)
+	rand.Read(data)
+
+	const clients = 4
+	var wg sync.WaitGroup
+	wg.Add(clients)
+	for i := 0; i < clients; i++ {
+		r, err := w.NewReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func(r amr.ReadSeekerCloser) {
+			defer func() {
+				r.Close()
+				wg.Done()
+			}()
+			var b bytes.Buffer
+			n, err := io.Copy(&b, r)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if n != int64(len(data)) {
+				t.Errorf("got %d bytes, want %d", n, len(data))
+				return
+			}
+			if !bytes.Equal(b.Bytes(), data) {
+				t.Error("read data differs from reference data")
+			}
+		}(r)
+	}
+
+	writeDone := make(chan struct{})
+	go func() {
+		defer close(writeDone)
+		if _, err := w.Write(data); err != nil {
+			t.Errorf("Write: %v", err)
+		}
+	}()
+
+	select {
+	case <-writeDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write did not complete; slowest reader should bound the writer's lead, not stall it")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+}
+
+func TestWriter_WithMaxLead_readAt(t *testing.T) {
+	name := testFileName()
+	defer removeTestFile(name)
+	const maxLead = 64 * 1024
+	w, err := amr.Create(name, amr.WithMaxLead(maxLead))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	r, err := w.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	data := make([]byte, 4*maxLead)
+	rand.Read(data)
+
+	writeDone := make(chan struct{})
+	go func() {
+		defer close(writeDone)
+		if _, err := w.Write(data); err != nil {
+			t.Errorf("Write: %v", err)
+		}
+	}()
+
+	// Drain purely via ReadAt: a reader that never advances r.r must still
+	// unblock the writer, since ReadAt tracks its own high-water mark.
+	b := make([]byte, 1024)
+	for off := int64(0); off < int64(len(data)); off += int64(len(b)) {
+		n, err := r.ReadAt(b, off)
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b[:n], data[off:off+int64(n)]) {
+			t.Fatal("ReadAt: data mismatch")
+		}
+	}
+
+	select {
+	case <-writeDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write did not unblock for a ReadAt-only consumer")
+	}
+}
+
+func TestMemFS_Concurrent(t *testing.T) {
+	name := testFileName()
+	defer removeTestFile(name)
+
+	w, err := amr.Create(name, amr.WithFileSystem(amr.NewMemFS()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data = make([]byte, 2048*1024)
+	rand.Read(data)
+
+	const clients = 4
+	var wg sync.WaitGroup
+	wg.Add(clients)
+	for i := 0; i < clients; i++ {
+		r, err := w.NewReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func(r amr.ReadSeekerCloser) {
+			defer func() {
+				r.Close()
+				wg.Done()
+			}()
+			var b bytes.Buffer
+			n, err := io.Copy(&b, r)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if n != int64(len(data)) {
+				t.Errorf("got %d bytes, want %d", n, len(data))
+				return
+			}
+			if !bytes.Equal(b.Bytes(), data) {
+				t.Error("read data differs from reference data")
+			}
+		}(r)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+}
+
+func TestMemFS_SeekReadAt(t *testing.T) {
+	name := testFileName()
+	defer removeTestFile(name)
+
+	w, err := amr.Create(name, amr.WithFileSystem(amr.NewMemFS()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if _, err := w.Write([]byte("Hello, World!")); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := w.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	b := make([]byte, 5)
+	if _, err := io.ReadFull(r, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "Hello" {
+		t.Fatalf("got %q, want %q", b, "Hello")
+	}
+
+	// seek forward
+	pos, err := r.Seek(7, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != 7 {
+		t.Fatalf("Seek: got position %d, want 7", pos)
+	}
+	if _, err := io.ReadFull(r, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "World" {
+		t.Fatalf("got %q, want %q", b, "World")
+	}
+
+	// ReadAt reads at an absolute offset, behind the cursor used by Read/Seek
+	rb := make([]byte, 5)
+	if _, err := r.ReadAt(rb, 0); err != nil {
+		t.Fatal(err)
+	}
+	if string(rb) != "Hello" {
+		t.Fatalf("ReadAt: got %q, want %q", rb, "Hello")
+	}
+}
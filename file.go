@@ -0,0 +1,78 @@
+// Copyright (c) 2016 Denis Bernard <db047h@gmail.com>
+// Use of this source code is governed by the MIT license
+// which can be found in the LICENSE file.
+
+package amr
+
+import (
+	"io"
+	"os"
+)
+
+// FileReader is an independent read handle on a File, as returned by
+// File.Open. Handles obtained from the same File can be used concurrently
+// from different goroutines without interfering with each other.
+type FileReader interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+}
+
+// File is the interface implemented by the backend storage of a Writer. The
+// Files of DiskFS implement it by wrapping an *os.File.
+type File interface {
+	io.WriterAt
+	io.Closer
+
+	// Name returns the name the File was created or opened with.
+	Name() string
+
+	// Open returns a new FileReader reading the File from the start,
+	// independent from any other reader obtained from the same File. It is
+	// used by Writer.NewReader to hand out fresh read handles.
+	Open() (FileReader, error)
+}
+
+// FileSystem creates and opens Files. It allows a Writer to be backed by
+// disk files, in-memory buffers, or any other storage able to satisfy
+// File.
+type FileSystem interface {
+	// Create creates the named File with mode 0666 (before umask). Create
+	// fails if a File with that name already exists.
+	Create(name string) (File, error)
+
+	// Open opens the existing named File for writing, without truncating
+	// it.
+	Open(name string) (File, error)
+}
+
+// DiskFS is the default FileSystem. It stores Files on the local disk,
+// backed by *os.File.
+var DiskFS FileSystem = diskFS{}
+
+type diskFS struct{}
+
+func (diskFS) Create(name string) (File, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return diskFile{f}, nil
+}
+
+func (diskFS) Open(name string) (File, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return diskFile{f}, nil
+}
+
+// diskFile adapts *os.File to the File interface.
+type diskFile struct {
+	*os.File
+}
+
+func (f diskFile) Open() (FileReader, error) {
+	return os.Open(f.File.Name())
+}
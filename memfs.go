@@ -0,0 +1,122 @@
+// Copyright (c) 2016 Denis Bernard <db047h@gmail.com>
+// Use of this source code is governed by the MIT license
+// which can be found in the LICENSE file.
+
+package amr
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// NewMemFS returns a FileSystem that stores File contents in memory rather
+// than on disk. It is intended for tests and for cache tiers that do not
+// need to hit disk.
+func NewMemFS() FileSystem {
+	return &memFS{files: make(map[string]*memFile)}
+}
+
+type memFS struct {
+	mu    sync.Mutex
+	files map[string]*memFile
+}
+
+func (fs *memFS) Create(name string) (File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.files[name]; ok {
+		return nil, &os.PathError{Op: "create", Path: name, Err: os.ErrExist}
+	}
+	f := &memFile{name: name}
+	fs.files[name] = f
+	return f, nil
+}
+
+// Open returns the File that Create made under name, not a new handle on it:
+// once that File has been closed, its WriteAt fails with os.ErrClosed.
+func (fs *memFS) Open(name string) (File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	f, ok := fs.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return f, nil
+}
+
+// memFile is an in-memory File: a growing byte slice guarded by a mutex,
+// with independent cursor-based readers handed out by Open.
+type memFile struct {
+	mu     sync.Mutex
+	name   string
+	buf    []byte
+	closed bool
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	end := off + int64(len(p))
+	if end > int64(len(f.buf)) {
+		buf := make([]byte, end)
+		copy(buf, f.buf)
+		f.buf = buf
+	}
+	copy(f.buf[off:end], p)
+	return len(p), nil
+}
+
+func (f *memFile) Close() error {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *memFile) Open() (FileReader, error) {
+	return &memReader{f: f}, nil
+}
+
+// memReader is a cursor-based FileReader over a memFile's buffer.
+type memReader struct {
+	f      *memFile
+	pos    int64
+	closed bool // guarded by f.mu
+}
+
+func (r *memReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *memReader) ReadAt(p []byte, off int64) (int, error) {
+	r.f.mu.Lock()
+	defer r.f.mu.Unlock()
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+	if off >= int64(len(r.f.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.f.buf[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *memReader) Close() error {
+	r.f.mu.Lock()
+	r.closed = true
+	r.f.mu.Unlock()
+	return nil
+}